@@ -0,0 +1,56 @@
+package vips
+
+import "sync"
+
+// Job is one resize task within a ResizeBatch call. All jobs in a batch
+// share the same source buffer - this is meant for generating several
+// thumbnail sizes from a single upload without re-reading it per size.
+type Job struct {
+	Options Options
+}
+
+// Result is the outcome of a single Job, in the same order as the Jobs
+// passed to ResizeBatch.
+type Result struct {
+	Data []byte
+	Err  error
+}
+
+// Batcher is implemented by Resizers that can serve a whole batch of Jobs
+// against one source buffer more cheaply than running Resize once per Job.
+// VipsResizer implements it by decoding and shrink-on-load'ing the source
+// once per group of jobs that agree on NoAutoRotate and reusing that
+// intermediate across every job in the group; see vips.go.
+type Batcher interface {
+	ResizeBatch(buf []byte, jobs []Job) []Result
+}
+
+// ResizeBatch runs jobs against buf. If the active Resizer implements
+// Batcher, its batch path is used (gated by the worker pool configured via
+// SetMaxConcurrency as a single unit); otherwise each job runs
+// currentResizer().Resize independently, with each one gated by the pool.
+func ResizeBatch(buf []byte, jobs []Job) []Result {
+	r := currentResizer()
+
+	if b, ok := r.(Batcher); ok {
+		release := acquire()
+		defer release()
+		return b.ResizeBatch(buf, jobs)
+	}
+
+	results := make([]Result, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job Job) {
+			defer wg.Done()
+			release := acquire()
+			defer release()
+			data, err := r.Resize(buf, job.Options)
+			results[i] = Result{Data: data, Err: err}
+		}(i, job)
+	}
+	wg.Wait()
+
+	return results
+}
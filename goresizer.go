@@ -0,0 +1,364 @@
+//go:build !cgo
+
+package vips
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+func defaultResizer() Resizer { return GoResizer{} }
+
+// Kernel selects the resampling filter GoResizer uses.
+type Kernel int
+
+const (
+	// KernelLanczos3 gives the best quality and is the default; it is
+	// roughly equivalent to libvips' bicubic interpolator.
+	KernelLanczos3 Kernel = iota
+	KernelBilinear
+	KernelNearest
+)
+
+// GoResizer is a pure-Go Resizer used when the libvips cgo dependency isn't
+// available. It only understands JPEG and PNG (the two formats the standard
+// library can decode/encode) and has no shrink-on-load optimisation, trading
+// some fidelity and speed for zero system dependencies.
+type GoResizer struct {
+	// Kernel selects the resampling filter. The zero value is KernelLanczos3.
+	Kernel Kernel
+}
+
+// Resize decodes buf, resizes/crops it per o and re-encodes it as o.Format
+// (defaulting to the detected input format).
+func (r GoResizer) Resize(buf []byte, o Options) ([]byte, error) {
+	src, format, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Format == UNKNOWN {
+		switch format {
+		case "jpeg":
+			o.Format = JPEG
+		case "png":
+			o.Format = PNG
+		default:
+			return nil, errors.New("goresizer: unsupported image format")
+		}
+	}
+	if o.Format != JPEG && o.Format != PNG {
+		return nil, errors.New("goresizer: can only encode jpeg or png")
+	}
+	if o.Rotate != ANGLE_0 {
+		return nil, errors.New("goresizer: Rotate is not supported by the pure-Go resizer")
+	}
+	if o.Flip != DIRECTION_NONE {
+		return nil, errors.New("goresizer: Flip is not supported by the pure-Go resizer")
+	}
+	if o.Extract {
+		return nil, errors.New("goresizer: Extract is not supported by the pure-Go resizer")
+	}
+	if o.Quality == 0 {
+		o.Quality = 100
+	}
+
+	b := src.Bounds()
+	inWidth, inHeight := b.Dx(), b.Dy()
+
+	embed := false
+	canvasWidth, canvasHeight := o.Width, o.Height
+	width, height := o.Width, o.Height
+	switch {
+	case width > 0 && height > 0:
+		if o.Crop {
+			// Fill the box, cropping the overflow.
+			width, height = cropFit(inWidth, inHeight, width, height)
+		} else {
+			// Fit inside the box without distorting the aspect ratio, then
+			// pad out to it below.
+			width, height = embedFit(inWidth, inHeight, width, height)
+			embed = true
+		}
+	case width > 0:
+		height = int(math.Round(float64(inHeight) * float64(width) / float64(inWidth)))
+	case height > 0:
+		width = int(math.Round(float64(inWidth) * float64(height) / float64(inHeight)))
+	default:
+		width, height = inWidth, inHeight
+	}
+
+	if !o.Enlarge && (width > inWidth || height > inHeight) {
+		width, height = inWidth, inHeight
+		// The canvas itself must shrink along with it, or the unenlarged
+		// image would just get padded back out to the originally requested
+		// (larger) box.
+		canvasWidth, canvasHeight = inWidth, inHeight
+	}
+
+	resized := resample(src, width, height, r.Kernel)
+
+	if o.Width > 0 && o.Height > 0 && o.Crop {
+		resized = cropToSize(resized, o.Width, o.Height, o.Gravity)
+	} else if embed {
+		resized = embedToSize(resized, canvasWidth, canvasHeight, o.Extend)
+	}
+
+	var out bytes.Buffer
+	switch o.Format {
+	case PNG:
+		err = png.Encode(&out, resized)
+	default:
+		err = jpeg.Encode(&out, resized, &jpeg.Options{Quality: o.Quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Metadata decodes just enough of img's buffer to report its dimensions and
+// channel count. Without libvips there's no EXIF access, so Space and
+// Orientation are always left at their zero value.
+func (img *Image) Metadata() (*Metadata, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img.buf))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{Width: cfg.Width, Height: cfg.Height, Channels: 3}
+	switch cfg.ColorModel {
+	case color.NRGBAModel, color.RGBAModel:
+		m.Channels, m.HasAlpha = 4, true
+	}
+	return m, nil
+}
+
+// cropFit returns the source's dimensions scaled by the smallest factor that
+// still covers width x height, so the subsequent crop only trims the overflow.
+func cropFit(inWidth, inHeight, width, height int) (int, int) {
+	xf := float64(inWidth) / float64(width)
+	yf := float64(inHeight) / float64(height)
+	factor := math.Min(xf, yf)
+	return int(math.Round(float64(inWidth) / factor)), int(math.Round(float64(inHeight) / factor))
+}
+
+// embedFit returns the largest box of the source's own aspect ratio that
+// fits inside width x height, so the subsequent embed only pads the
+// letterbox bars rather than distorting the image.
+func embedFit(inWidth, inHeight, width, height int) (int, int) {
+	xf := float64(inWidth) / float64(width)
+	yf := float64(inHeight) / float64(height)
+	factor := math.Max(xf, yf)
+	return int(math.Round(float64(inWidth) / factor)), int(math.Round(float64(inHeight) / factor))
+}
+
+// embedToSize pads src onto a width x height canvas filled with extend's
+// colour, centring src within it.
+func embedToSize(src *image.NRGBA, width, height int, extend Extend) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if extend == EXTEND_WHITE {
+		draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.NRGBA{R: 255, G: 255, B: 255, A: 255}}, image.Point{}, draw.Src)
+	}
+
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	left := (width - srcW) / 2
+	top := (height - srcH) / 2
+	draw.Draw(dst, image.Rect(left, top, left+srcW, top+srcH), src, image.Point{}, draw.Src)
+	return dst
+}
+
+func cropToSize(src *image.NRGBA, width, height int, gravity Gravity) *image.NRGBA {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	if width > srcW {
+		width = srcW
+	}
+	if height > srcH {
+		height = srcH
+	}
+
+	left, top := sharpCalcCrop(srcW, srcH, width, height, gravity, nrgbaEnergySource{src})
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), src, image.Pt(left, top), draw.Src)
+	return dst
+}
+
+// nrgbaEnergySource adapts an *image.NRGBA to EnergySource for SMART/ENTROPY
+// gravity, using the pixel's ITU-R BT.601 luma as intensity.
+type nrgbaEnergySource struct {
+	img *image.NRGBA
+}
+
+func (s nrgbaEnergySource) At(x, y int) uint8 {
+	b := s.img.Bounds()
+	px := s.img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+	return uint8((299*int(px.R) + 587*int(px.G) + 114*int(px.B)) / 1000)
+}
+
+func resample(src image.Image, width, height int, kernel Kernel) *image.NRGBA {
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	b := src.Bounds()
+	work := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(work, work.Bounds(), src, b.Min, draw.Src)
+
+	if width == work.Bounds().Dx() && height == work.Bounds().Dy() {
+		return work
+	}
+
+	switch kernel {
+	case KernelNearest:
+		return resampleNearest(work, width, height)
+	case KernelBilinear:
+		return resampleSeparable(work, width, height, bilinearKernel, 1)
+	default:
+		return resampleSeparable(work, width, height, lanczos3Kernel, 3)
+	}
+}
+
+func resampleNearest(src *image.NRGBA, dstW, dstH int) *image.NRGBA {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	xScale := float64(srcW) / float64(dstW)
+	yScale := float64(srcH) / float64(dstH)
+
+	for y := 0; y < dstH; y++ {
+		sy := int(float64(y) * yScale)
+		for x := 0; x < dstW; x++ {
+			sx := int(float64(x) * xScale)
+			dst.SetNRGBA(x, y, src.NRGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+func bilinearKernel(x float64) float64 {
+	x = math.Abs(x)
+	if x < 1 {
+		return 1 - x
+	}
+	return 0
+}
+
+func lanczos3Kernel(x float64) float64 {
+	const a = 3.0
+	if x == 0 {
+		return 1
+	}
+	if x < -a || x > a {
+		return 0
+	}
+	piX := math.Pi * x
+	return a * math.Sin(piX) * math.Sin(piX/a) / (piX * piX)
+}
+
+// resampleSeparable resizes src to dstW x dstH using two one-dimensional
+// passes of kernel (support is the kernel's half-width in source-pixel units
+// at scale 1, e.g. 3 for Lanczos3, 1 for bilinear).
+func resampleSeparable(src *image.NRGBA, dstW, dstH int, kernel func(float64) float64, support float64) *image.NRGBA {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	horizontal := resizeAxis(src, srcW, srcH, dstW, srcH, true, kernel, support)
+	return resizeAxis(horizontal, dstW, srcH, dstW, dstH, false, kernel, support)
+}
+
+// resizeAxis resamples along one axis (x when horizontal, y otherwise).
+func resizeAxis(src *image.NRGBA, srcW, srcH, dstW, dstH int, horizontal bool, kernel func(float64) float64, support float64) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+
+	srcLen, dstLen := srcW, dstW
+	if !horizontal {
+		srcLen, dstLen = srcH, dstH
+	}
+
+	scale := float64(srcLen) / float64(dstLen)
+	filterScale := math.Max(scale, 1.0)
+	radius := support * filterScale
+
+	for d := 0; d < dstLen; d++ {
+		center := (float64(d)+0.5)*scale - 0.5
+		lo := int(math.Floor(center - radius))
+		hi := int(math.Ceil(center + radius))
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > srcLen-1 {
+			hi = srcLen - 1
+		}
+		if hi < lo {
+			hi = lo
+		}
+
+		weights := make([]float64, hi-lo+1)
+		var sum float64
+		for i := lo; i <= hi; i++ {
+			w := kernel((float64(i) - center) / filterScale)
+			weights[i-lo] = w
+			sum += w
+		}
+		if sum != 0 {
+			for i := range weights {
+				weights[i] /= sum
+			}
+		}
+
+		if horizontal {
+			for y := 0; y < srcH; y++ {
+				r, g, b, a := weightedSum(src, lo, hi, y, weights, true)
+				dst.SetNRGBA(d, y, toNRGBA(r, g, b, a))
+			}
+		} else {
+			for x := 0; x < srcW; x++ {
+				r, g, b, a := weightedSum(src, lo, hi, x, weights, false)
+				dst.SetNRGBA(x, d, toNRGBA(r, g, b, a))
+			}
+		}
+	}
+	return dst
+}
+
+func weightedSum(src *image.NRGBA, lo, hi, fixed int, weights []float64, horizontal bool) (r, g, b, a float64) {
+	for i := lo; i <= hi; i++ {
+		w := weights[i-lo]
+		var px color.NRGBA
+		if horizontal {
+			px = src.NRGBAAt(i, fixed)
+		} else {
+			px = src.NRGBAAt(fixed, i)
+		}
+		r += float64(px.R) * w
+		g += float64(px.G) * w
+		b += float64(px.B) * w
+		a += float64(px.A) * w
+	}
+	return
+}
+
+func toNRGBA(r, g, b, a float64) (px color.NRGBA) {
+	px.R = clamp8(r)
+	px.G = clamp8(g)
+	px.B = clamp8(b)
+	px.A = clamp8(a)
+	return
+}
+
+func clamp8(v float64) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
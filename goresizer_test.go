@@ -0,0 +1,96 @@
+//go:build !cgo
+
+package vips
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// solidPNG encodes a w x h image filled with c as PNG.
+func solidPNG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode source: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestGoResizerEmbedsWithoutDistorting(t *testing.T) {
+	src := solidPNG(t, 100, 50, color.NRGBA{R: 255, A: 255})
+
+	out, err := (GoResizer{}).Resize(src, Options{Width: 50, Height: 50})
+	if err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode result: %v", err)
+	}
+	b := img.Bounds()
+	if b.Dx() != 50 || b.Dy() != 50 {
+		t.Fatalf("got %dx%d, want 50x50", b.Dx(), b.Dy())
+	}
+
+	// The 100x50 source only fits a 50x50 box by shrinking to 50x25 and
+	// letterboxing; a distorted (stretched) result would instead fill the
+	// whole canvas with red, with no black bar.
+	if r, _, _, _ := img.At(0, 0).RGBA(); r != 0 {
+		t.Fatalf("expected a black letterbox bar at (0,0), got red channel %d", r)
+	}
+	if r, _, _, _ := img.At(25, 25).RGBA(); r == 0 {
+		t.Fatalf("expected red at the centre (25,25), got black")
+	}
+}
+
+func TestGoResizerDoesNotPadWhenNotEnlarging(t *testing.T) {
+	src := solidPNG(t, 50, 50, color.NRGBA{R: 255, A: 255})
+
+	out, err := (GoResizer{}).Resize(src, Options{Width: 200, Height: 200})
+	if err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode result: %v", err)
+	}
+	b := img.Bounds()
+	// Enlarge defaults to false, so a 50x50 source asked for a 200x200 box
+	// should come back at its own 50x50 size, not padded out to 200x200.
+	if b.Dx() != 50 || b.Dy() != 50 {
+		t.Fatalf("got %dx%d, want 50x50", b.Dx(), b.Dy())
+	}
+}
+
+func TestGoResizerRejectsUnsupportedOptions(t *testing.T) {
+	src := solidPNG(t, 10, 10, color.NRGBA{A: 255})
+	r := GoResizer{}
+
+	cases := []struct {
+		name string
+		o    Options
+	}{
+		{"Rotate", Options{Rotate: ANGLE_90}},
+		{"Flip", Options{Flip: VERTICAL}},
+		{"Extract", Options{Extract: true, Width: 5, Height: 5}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := r.Resize(src, c.o); err == nil {
+				t.Fatalf("expected an error, got nil")
+			}
+		})
+	}
+}
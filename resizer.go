@@ -0,0 +1,77 @@
+package vips
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Resizer performs the actual resize/convert work for Resize. VipsResizer
+// (cgo, libvips-backed) and GoResizer (pure Go) are the two implementations;
+// which one is compiled in is chosen by the cgo build tag, and SetResizer
+// lets a caller override it at runtime.
+type Resizer interface {
+	Resize(buf []byte, o Options) ([]byte, error)
+}
+
+var (
+	resizerMu sync.RWMutex
+	resizer   = defaultResizer()
+)
+
+// SetResizer overrides the package-level Resizer used by Resize.
+func SetResizer(r Resizer) {
+	resizerMu.Lock()
+	defer resizerMu.Unlock()
+	resizer = r
+}
+
+func currentResizer() Resizer {
+	resizerMu.RLock()
+	defer resizerMu.RUnlock()
+	return resizer
+}
+
+var (
+	concurrencyMu sync.RWMutex
+	concurrency   chan struct{} // nil means unlimited
+)
+
+// SetMaxConcurrency caps how many Resize/ResizeBatch jobs run at once, since
+// each one can run a full resize pipeline that allocates many multiples of
+// the image's size. n <= 0 removes the cap, which is the default.
+func SetMaxConcurrency(n int) {
+	concurrencyMu.Lock()
+	defer concurrencyMu.Unlock()
+	if n <= 0 {
+		concurrency = nil
+		return
+	}
+	concurrency = make(chan struct{}, n)
+}
+
+// acquire reserves a slot in the worker pool configured by SetMaxConcurrency
+// and returns a func to release it. It's a no-op when no limit is set.
+func acquire() (release func()) {
+	concurrencyMu.RLock()
+	slots := concurrency
+	concurrencyMu.RUnlock()
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// Resize reads the image in reader and runs it through the active Resizer.
+func Resize(reader io.Reader, o Options) ([]byte, error) {
+	buf, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	release := acquire()
+	defer release()
+
+	return currentResizer().Resize(buf, o)
+}
@@ -0,0 +1,5 @@
+//go:build cgo
+
+package vips
+
+func defaultResizer() Resizer { return VipsResizer{} }
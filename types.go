@@ -0,0 +1,423 @@
+package vips
+
+import (
+	"bytes"
+	"math"
+)
+
+// These types, plus Options and Image, have no cgo dependency so that they
+// (and the Resizer interface in resizer.go) stay usable when the package is
+// built with the pure-Go fallback resizer (see goresizer.go).
+
+var (
+	MARKER_JPEG    = []byte{0xff, 0xd8}
+	MARKER_PNG     = []byte{0x89, 0x50}
+	MARKER_TIFF    = []byte{0x49, 0x49}
+	MARKER_TIFF_BE = []byte{0x4d, 0x4d}
+	MARKER_RIFF    = []byte{0x52, 0x49, 0x46, 0x46}
+	MARKER_WEBP    = []byte{0x57, 0x45, 0x42, 0x50}
+)
+
+type ImageType int
+
+const (
+	UNKNOWN ImageType = iota
+	JPEG
+	PNG
+	WEBP
+	TIFF
+)
+
+func (t ImageType) String() string {
+	switch t {
+	case JPEG:
+		return "jpeg"
+	case PNG:
+		return "png"
+	case WEBP:
+		return "webp"
+	case TIFF:
+		return "tiff"
+	default:
+		return "unknown"
+	}
+}
+
+// detectImageType sniffs the first bytes of buf for a known magic marker.
+// buf must hold at least 12 bytes.
+func detectImageType(buf []byte) ImageType {
+	switch {
+	case buf[0] == MARKER_JPEG[0] && buf[1] == MARKER_JPEG[1]:
+		return JPEG
+	case buf[0] == MARKER_PNG[0] && buf[1] == MARKER_PNG[1]:
+		return PNG
+	case bytes.Equal(buf[0:4], MARKER_RIFF) && bytes.Equal(buf[8:12], MARKER_WEBP):
+		return WEBP
+	case (buf[0] == MARKER_TIFF[0] && buf[1] == MARKER_TIFF[1]) || (buf[0] == MARKER_TIFF_BE[0] && buf[1] == MARKER_TIFF_BE[1]):
+		return TIFF
+	default:
+		return UNKNOWN
+	}
+}
+
+type Interpolator int
+
+const (
+	BICUBIC Interpolator = iota
+	BILINEAR
+	NOHALO
+)
+
+var interpolations = map[Interpolator]string{
+	BICUBIC:  "bicubic",
+	BILINEAR: "bilinear",
+	NOHALO:   "nohalo",
+}
+
+func (i Interpolator) String() string { return interpolations[i] }
+
+// Extend mirrors libvips' VipsExtend enum values, so the cgo pipeline can
+// cast an Extend straight to a C.VipsExtend.
+type Extend int
+
+const (
+	EXTEND_BLACK Extend = 0
+	EXTEND_WHITE Extend = 4
+)
+
+// Angle is a vips_rot rotation, expressed in multiples of 90 degrees. Values
+// mirror libvips' VipsAngle enum.
+type Angle int
+
+const (
+	ANGLE_0 Angle = iota
+	ANGLE_90
+	ANGLE_180
+	ANGLE_270
+)
+
+// Direction selects the axis a Flip mirrors around. The zero value means
+// "don't flip" so Options can leave it unset.
+type Direction int
+
+const (
+	DIRECTION_NONE Direction = iota
+	HORIZONTAL
+	VERTICAL
+)
+
+type Gravity int
+
+const (
+	CENTRE Gravity = iota
+	NORTH
+	EAST
+	SOUTH
+	WEST
+	// SMART picks the crop window with the highest luminance-gradient energy
+	// (squared forward differences), favouring busy/detailed regions over
+	// flat ones.
+	SMART
+	// ENTROPY picks the crop window with the highest Shannon entropy of its
+	// intensity histogram, favouring visually complex regions.
+	ENTROPY
+)
+
+// EnergySource supplies per-pixel intensity (0-255) for SMART/ENTROPY
+// gravity scoring. VipsResizer and GoResizer each adapt their own decoded
+// image to this so sharpCalcCrop stays independent of either.
+type EnergySource interface {
+	At(x, y int) uint8
+}
+
+// sharpCalcCrop returns the (left, top) offset of the outWidth x outHeight
+// window to crop out of a inWidth x inHeight image for gravity. src is only
+// consulted for SMART/ENTROPY; callers that can't supply pixel data (or any
+// other gravity) may pass nil, which falls back to CENTRE.
+func sharpCalcCrop(inWidth, inHeight, outWidth, outHeight int, gravity Gravity, src EnergySource) (int, int) {
+	if src != nil {
+		switch gravity {
+		case SMART:
+			return smartCrop(inWidth, inHeight, outWidth, outHeight, src)
+		case ENTROPY:
+			return entropyCrop(inWidth, inHeight, outWidth, outHeight, src)
+		}
+	}
+
+	left, top := 0, 0
+	switch gravity {
+	case NORTH:
+		left = (inWidth - outWidth + 1) / 2
+	case EAST:
+		left = inWidth - outWidth
+		top = (inHeight - outHeight + 1) / 2
+	case SOUTH:
+		left = (inWidth - outWidth + 1) / 2
+		top = inHeight - outHeight
+	case WEST:
+		top = (inHeight - outHeight + 1) / 2
+	default:
+		left = (inWidth - outWidth + 1) / 2
+		top = (inHeight - outHeight + 1) / 2
+	}
+	return left, top
+}
+
+// energyGridMax bounds the side of the grid smartCrop/entropyCrop score
+// against, so a large source image is sampled on a coarse grid rather than
+// scored per pixel.
+const energyGridMax = 256
+
+// smartCrop scores a coarse luminance-gradient energy map with a summed-area
+// table and returns the window with the highest total energy.
+func smartCrop(inWidth, inHeight, outWidth, outHeight int, src EnergySource) (int, int) {
+	strideX, strideY := gridStride(inWidth), gridStride(inHeight)
+	gridW, gridH := gridSize(inWidth, strideX), gridSize(inHeight, strideY)
+
+	lum := make([][]int, gridH)
+	for y := range lum {
+		lum[y] = make([]int, gridW)
+		for x := range lum[y] {
+			lum[y][x] = int(src.At(x*strideX, y*strideY))
+		}
+	}
+
+	energy := make([][]int, gridH)
+	for y := range energy {
+		energy[y] = make([]int, gridW)
+		for x := range energy[y] {
+			gx, gy := 0, 0
+			if x+1 < gridW {
+				gx = lum[y][x+1] - lum[y][x]
+			}
+			if y+1 < gridH {
+				gy = lum[y+1][x] - lum[y][x]
+			}
+			energy[y][x] = gx*gx + gy*gy
+		}
+	}
+
+	outGridW, outGridH := gridWindow(outWidth, strideX, gridW), gridWindow(outHeight, strideY, gridH)
+	sat := summedAreaTable(energy)
+
+	bestLeft, bestTop, bestScore := 0, 0, -1
+	for top := 0; top+outGridH <= gridH; top++ {
+		for left := 0; left+outGridW <= gridW; left++ {
+			if score := windowSum(sat, left, top, outGridW, outGridH); score > bestScore {
+				bestScore, bestLeft, bestTop = score, left, top
+			}
+		}
+	}
+
+	return clampCrop(bestLeft*strideX, bestTop*strideY, inWidth, inHeight, outWidth, outHeight)
+}
+
+// entropyBins is the number of intensity buckets entropyCrop computes
+// Shannon entropy over.
+const entropyBins = 16
+
+// entropyCrop returns the window with the highest Shannon entropy of its
+// intensity histogram, built from entropyBins summed-area tables (one per
+// bucket) so each candidate window's histogram is a handful of lookups
+// rather than a full rescan.
+func entropyCrop(inWidth, inHeight, outWidth, outHeight int, src EnergySource) (int, int) {
+	strideX, strideY := gridStride(inWidth), gridStride(inHeight)
+	gridW, gridH := gridSize(inWidth, strideX), gridSize(inHeight, strideY)
+
+	bucketCounts := make([][][]int, entropyBins)
+	for b := range bucketCounts {
+		bucketCounts[b] = make([][]int, gridH)
+		for y := range bucketCounts[b] {
+			bucketCounts[b][y] = make([]int, gridW)
+		}
+	}
+	for y := 0; y < gridH; y++ {
+		for x := 0; x < gridW; x++ {
+			bucket := int(src.At(x*strideX, y*strideY)) * entropyBins / 256
+			bucketCounts[bucket][y][x] = 1
+		}
+	}
+	sats := make([][][]int, entropyBins)
+	for b := range bucketCounts {
+		sats[b] = summedAreaTable(bucketCounts[b])
+	}
+
+	outGridW, outGridH := gridWindow(outWidth, strideX, gridW), gridWindow(outHeight, strideY, gridH)
+	windowArea := float64(outGridW * outGridH)
+
+	bestLeft, bestTop, bestEntropy := 0, 0, -1.0
+	for top := 0; top+outGridH <= gridH; top++ {
+		for left := 0; left+outGridW <= gridW; left++ {
+			var h float64
+			for b := range sats {
+				if count := windowSum(sats[b], left, top, outGridW, outGridH); count > 0 {
+					p := float64(count) / windowArea
+					h -= p * math.Log2(p)
+				}
+			}
+			if h > bestEntropy {
+				bestEntropy, bestLeft, bestTop = h, left, top
+			}
+		}
+	}
+
+	return clampCrop(bestLeft*strideX, bestTop*strideY, inWidth, inHeight, outWidth, outHeight)
+}
+
+func gridStride(dim int) int {
+	if dim > energyGridMax {
+		return dim / energyGridMax
+	}
+	return 1
+}
+
+func gridSize(dim, stride int) int {
+	return (dim + stride - 1) / stride
+}
+
+func gridWindow(outDim, stride, gridDim int) int {
+	w := outDim / stride
+	if w < 1 {
+		w = 1
+	}
+	if w > gridDim {
+		w = gridDim
+	}
+	return w
+}
+
+// clampCrop keeps a (left, top) offset computed on the coarse energy grid
+// inside the full-resolution image bounds.
+func clampCrop(left, top, inWidth, inHeight, outWidth, outHeight int) (int, int) {
+	if left+outWidth > inWidth {
+		left = inWidth - outWidth
+	}
+	if top+outHeight > inHeight {
+		top = inHeight - outHeight
+	}
+	if left < 0 {
+		left = 0
+	}
+	if top < 0 {
+		top = 0
+	}
+	return left, top
+}
+
+// summedAreaTable builds a (len(v)+1) x (len(v[0])+1) prefix-sum table so
+// windowSum can total any rectangle of v in constant time.
+func summedAreaTable(v [][]int) [][]int {
+	h := len(v)
+	if h == 0 {
+		return nil
+	}
+	w := len(v[0])
+	sat := make([][]int, h+1)
+	for y := range sat {
+		sat[y] = make([]int, w+1)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sat[y+1][x+1] = v[y][x] + sat[y][x+1] + sat[y+1][x] - sat[y][x]
+		}
+	}
+	return sat
+}
+
+func windowSum(sat [][]int, left, top, w, h int) int {
+	return sat[top+h][left+w] - sat[top][left+w] - sat[top+h][left] + sat[top][left]
+}
+
+type Options struct {
+	Height       int
+	Width        int
+	Crop         bool
+	Enlarge      bool
+	Extend       Extend
+	Interpolator Interpolator
+	Gravity      Gravity
+	Quality      int
+	// Format requests the output image type. It defaults to the detected
+	// input format, so a caller that wants e.g. PNG -> WebP conversion
+	// must set this explicitly.
+	Format ImageType
+	// Rotate and Flip apply after the resize pipeline, before the final
+	// colour space conversion.
+	Rotate Angle
+	Flip   Direction
+	// Extract, when true, ignores Width/Height for resizing purposes and
+	// instead crops the literal (Left, Top, Width, Height) rectangle out of
+	// the (otherwise untouched) source image.
+	Extract bool
+	Top     int
+	Left    int
+	// NoAutoRotate disables the default behaviour of correcting the image
+	// for its EXIF orientation tag before resizing. Only VipsResizer
+	// auto-rotates; GoResizer never reads the EXIF orientation.
+	NoAutoRotate bool
+}
+
+// Metadata describes an image's dimensions and colour information.
+type Metadata struct {
+	Width    int
+	Height   int
+	Channels int
+	// Space holds the raw VipsInterpretation colour-space code (e.g.
+	// C.VIPS_INTERPRETATION_sRGB). Only populated by VipsResizer builds.
+	Space    int
+	HasAlpha bool
+	// Orientation is the EXIF orientation tag (1-8), or 1 (normal) if absent.
+	// Only populated by VipsResizer builds; pure-Go builds leave it at 0.
+	Orientation int
+}
+
+// Image wraps an encoded image buffer and provides chainable, single-purpose
+// operations built on top of Process/Resize.
+type Image struct {
+	buf []byte
+}
+
+// NewImage wraps an already-read, encoded image buffer.
+func NewImage(buf []byte) *Image {
+	return &Image{buf: buf}
+}
+
+// Process runs the full resize/convert pipeline with the given options.
+func (img *Image) Process(o Options) ([]byte, error) {
+	return currentResizer().Resize(img.buf, o)
+}
+
+// Rotate rotates the image by a multiple of 90 degrees.
+func (img *Image) Rotate(a Angle) ([]byte, error) {
+	return img.Process(Options{Rotate: a})
+}
+
+// Flip mirrors the image top-to-bottom.
+func (img *Image) Flip() ([]byte, error) {
+	return img.Process(Options{Flip: VERTICAL})
+}
+
+// Flop mirrors the image left-to-right.
+func (img *Image) Flop() ([]byte, error) {
+	return img.Process(Options{Flip: HORIZONTAL})
+}
+
+// Extract crops the (top, left, width, height) rectangle out of the image,
+// leaving the rest of the pipeline untouched.
+func (img *Image) Extract(top, left, width, height int) ([]byte, error) {
+	return img.Process(Options{Extract: true, Top: top, Left: left, Width: width, Height: height})
+}
+
+// Convert re-encodes the image as t.
+func (img *Image) Convert(t ImageType) ([]byte, error) {
+	return img.Process(Options{Format: t})
+}
+
+// Size returns the image's pixel dimensions without running the resize pipeline.
+func (img *Image) Size() (width, height int, err error) {
+	m, err := img.Metadata()
+	if err != nil {
+		return 0, 0, err
+	}
+	return m.Width, m.Height, nil
+}
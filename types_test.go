@@ -0,0 +1,74 @@
+package vips
+
+import "testing"
+
+// gridEnergySource is a fixed intensity grid, for exercising
+// smartCrop/entropyCrop without decoding a real image.
+type gridEnergySource struct {
+	w, h int
+	px   []uint8 // row-major, len == w*h
+}
+
+func (g gridEnergySource) At(x, y int) uint8 { return g.px[y*g.w+x] }
+
+func uniformGrid(w, h int, v uint8) gridEnergySource {
+	px := make([]uint8, w*h)
+	for i := range px {
+		px[i] = v
+	}
+	return gridEnergySource{w: w, h: h, px: px}
+}
+
+func TestSmartCropPicksHighestEnergyWindow(t *testing.T) {
+	const w, h = 12, 12
+	src := uniformGrid(w, h, 10)
+	// A sharp edge at x=9 gives the gradient-energy window ending there a
+	// much higher score than anywhere in the flat region to its left.
+	for y := 0; y < h; y++ {
+		src.px[y*w+9] = 250
+	}
+
+	left, top := sharpCalcCrop(w, h, 4, h, SMART, src)
+	if left < 5 {
+		t.Fatalf("expected the crop window to cover the sharp edge near x=9, got left=%d top=%d", left, top)
+	}
+}
+
+func TestEntropyCropPicksBusiestWindow(t *testing.T) {
+	const w, h = 12, 12
+	src := uniformGrid(w, h, 10)
+	// A varied block on the right gives that region a much higher
+	// histogram entropy than the uniform region on the left.
+	for y := 0; y < h; y++ {
+		for x := 8; x < w; x++ {
+			src.px[y*w+x] = uint8((x*31 + y*17) % 256)
+		}
+	}
+
+	left, _ := sharpCalcCrop(w, h, 4, h, ENTROPY, src)
+	if left < 5 {
+		t.Fatalf("expected the crop window to cover the busy region near x=8..11, got left=%d", left)
+	}
+}
+
+func TestSharpCalcCropFallsBackToCentreWithoutEnergySource(t *testing.T) {
+	left, top := sharpCalcCrop(100, 100, 50, 50, SMART, nil)
+	if left != 25 || top != 25 {
+		t.Fatalf("expected a centred crop with no EnergySource, got left=%d top=%d", left, top)
+	}
+}
+
+func TestSummedAreaTableWindowSum(t *testing.T) {
+	v := [][]int{
+		{1, 2, 3},
+		{4, 5, 6},
+		{7, 8, 9},
+	}
+	sat := summedAreaTable(v)
+	if got := windowSum(sat, 0, 0, 3, 3); got != 45 {
+		t.Fatalf("windowSum over the whole table = %d, want 45", got)
+	}
+	if got := windowSum(sat, 1, 1, 2, 2); got != 5+6+8+9 {
+		t.Fatalf("windowSum over bottom-right 2x2 = %d, want %d", got, 5+6+8+9)
+	}
+}
@@ -1,3 +1,5 @@
+//go:build cgo
+
 package vips
 
 /*
@@ -9,8 +11,6 @@ import "C"
 import (
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"math"
 	"os"
 	"time"
@@ -19,51 +19,92 @@ import (
 
 const DEBUG = true
 
-var (
-	MARKER_JPEG = []byte{0xff, 0xd8}
-	MARKER_PNG  = []byte{0x89, 0x50}
-)
-
-type ImageType int
-
-const (
-	UNKNOWN ImageType = iota
-	JPEG
-	PNG
-)
+// loadBuffer feeds buf into *out using the loader for typ.
+func loadBuffer(buf []byte, typ ImageType, out **C.VipsImage) {
+	switch typ {
+	case JPEG:
+		C.vips_jpegload_buffer_rand(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), out)
+	case PNG:
+		C.vips_pngload_buffer_rand(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), out)
+	case WEBP:
+		C.vips_webpload_buffer_rand(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), out)
+	case TIFF:
+		C.vips_tiffload_buffer_rand(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), out)
+	}
+}
 
-type Interpolator int
+// orientationField is the EXIF tag libvips exposes the JPEG Orientation value under.
+const orientationField = "exif-ifd0-Orientation"
 
-const (
-	BICUBIC Interpolator = iota
-	BILINEAR
-	NOHALO
-)
+// orientationOf reads the EXIF orientation tag, defaulting to 1 (normal) when absent.
+func orientationOf(in *C.VipsImage) int {
+	orientation := C.int(1)
+	C.vips_image_get_int(in, C.CString(orientationField), &orientation)
+	return int(orientation)
+}
 
-type Extend int
+// applyOrientation rotates/flips in to undo the given EXIF orientation and
+// strips the tag from the result so viewers don't double-rotate it.
+func applyOrientation(in *C.VipsImage, orientation int) (*C.VipsImage, error) {
+	out := C.vips_image_new()
 
-const (
-	EXTEND_BLACK Extend = C.VIPS_EXTEND_BLACK
-	EXTEND_WHITE Extend = C.VIPS_EXTEND_WHITE
-)
+	switch orientation {
+	case 2:
+		if err := C.vips_flip_0(in, &out, C.VIPS_DIRECTION_HORIZONTAL); err != 0 {
+			return nil, resizeError()
+		}
+	case 3:
+		if err := C.vips_rot_0(in, &out, C.VIPS_ANGLE_D180); err != 0 {
+			return nil, resizeError()
+		}
+	case 4:
+		if err := C.vips_flip_0(in, &out, C.VIPS_DIRECTION_VERTICAL); err != 0 {
+			return nil, resizeError()
+		}
+	case 5:
+		transposed := C.vips_image_new()
+		if err := C.vips_rot_0(in, &transposed, C.VIPS_ANGLE_D90); err != 0 {
+			return nil, resizeError()
+		}
+		err := C.vips_flip_0(transposed, &out, C.VIPS_DIRECTION_HORIZONTAL)
+		C.im_close(transposed)
+		if err != 0 {
+			return nil, resizeError()
+		}
+	case 6:
+		if err := C.vips_rot_0(in, &out, C.VIPS_ANGLE_D90); err != 0 {
+			return nil, resizeError()
+		}
+	case 7:
+		transposed := C.vips_image_new()
+		if err := C.vips_rot_0(in, &transposed, C.VIPS_ANGLE_D270); err != 0 {
+			return nil, resizeError()
+		}
+		err := C.vips_flip_0(transposed, &out, C.VIPS_DIRECTION_HORIZONTAL)
+		C.im_close(transposed)
+		if err != 0 {
+			return nil, resizeError()
+		}
+	case 8:
+		if err := C.vips_rot_0(in, &out, C.VIPS_ANGLE_D270); err != 0 {
+			return nil, resizeError()
+		}
+	default:
+		C.vips_copy_0(in, &out)
+		return out, nil
+	}
 
-var interpolations = map[Interpolator]string{
-	BICUBIC:  "bicubic",
-	BILINEAR: "bilinear",
-	NOHALO:   "nohalo",
+	C.vips_image_remove(out, C.CString(orientationField))
+	return out, nil
 }
 
-func (i Interpolator) String() string { return interpolations[i] }
-
-type Options struct {
-	Height       int
-	Width        int
-	Crop         bool
-	Enlarge      bool
-	Extend       Extend
-	Interpolator Interpolator
-	Gravity      Gravity
-	Quality      int
+// vipsDirection translates the package-level Direction into the C enum
+// vips_flip expects.
+func (d Direction) vipsDirection() C.int {
+	if d == VERTICAL {
+		return C.VIPS_DIRECTION_VERTICAL
+	}
+	return C.VIPS_DIRECTION_HORIZONTAL
 }
 
 func init() {
@@ -72,110 +113,259 @@ func init() {
 	C.vips_cache_set_max(500)
 }
 
-func Resize(reader io.Reader, o Options) ([]byte, error) {
+// VipsResizer is the cgo-backed Resizer, implemented on top of libvips.
+type VipsResizer struct{}
+
+// Resize runs buf through the libvips pipeline.
+func (VipsResizer) Resize(buf []byte, o Options) ([]byte, error) {
+	return vipsResize(buf, o)
+}
+
+// ResizeBatch implements Batcher: it splits jobs into groups that agree on
+// NoAutoRotate (orientation is baked into the decoded pixels, so jobs that
+// disagree can't share one oriented intermediate), then within each group
+// decodes and orients buf once and reuses that single intermediate as the
+// source for every job in the group, shrink-on-loading it at the factor the
+// highest-resolution job in the group needs so no job is ever served from a
+// decode with less resolution than it asked for.
+func (VipsResizer) ResizeBatch(buf []byte, jobs []Job) []Result {
+	results := make([]Result, len(jobs))
+	if len(jobs) == 0 {
+		return results
+	}
+
+	if len(buf) < 12 {
+		return fillResultErr(results, errors.New("unknown image format"))
+	}
+	typ := detectImageType(buf)
+	if typ == UNKNOWN {
+		return fillResultErr(results, errors.New("unknown image format"))
+	}
+
+	var rotated, unrotated []int
+	for i, job := range jobs {
+		if job.Options.NoAutoRotate {
+			unrotated = append(unrotated, i)
+		} else {
+			rotated = append(rotated, i)
+		}
+	}
+
+	fillGroup := func(indices []int) {
+		if len(indices) == 0 {
+			return
+		}
+		groupJobs := make([]Job, len(indices))
+		for gi, idx := range indices {
+			groupJobs[gi] = jobs[idx]
+		}
+		groupResults := resizeBatchGroup(buf, typ, groupJobs)
+		for gi, idx := range indices {
+			results[idx] = groupResults[gi]
+		}
+	}
+
+	fillGroup(rotated)
+	fillGroup(unrotated)
+
+	return results
+}
+
+// resizeBatchGroup runs jobs that all agree on NoAutoRotate against one
+// decoded-and-oriented intermediate, shared at the smallest (i.e.
+// highest-resolution) shrink-on-load factor any job in the group needs.
+func resizeBatchGroup(buf []byte, typ ImageType, jobs []Job) []Result {
+	results := make([]Result, len(jobs))
+
+	oriented, orientation, cleanup, err := loadAndOrient(buf, typ, jobs[0].Options)
+	if err != nil {
+		return fillResultErr(results, err)
+	}
+	defer cleanup()
+
+	type jobPlan struct {
+		width, height, shrink int
+		residual              float64
+		shrinkOnLoad          int
+	}
+	plans := make([]jobPlan, len(jobs))
+	sharedShrinkOnLoad := 0
+	for i, job := range jobs {
+		width, height, shrink, residual, shrinkOnLoad := calcShrink(int(oriented.Xsize), int(oriented.Ysize), typ, job.Options)
+		plans[i] = jobPlan{width, height, shrink, residual, shrinkOnLoad}
+		if sharedShrinkOnLoad == 0 || shrinkOnLoad < sharedShrinkOnLoad {
+			sharedShrinkOnLoad = shrinkOnLoad
+		}
+	}
+
+	src, srcCleanup, err := loadShrunk(buf, typ, orientation, sharedShrinkOnLoad, oriented)
+	if err != nil {
+		return fillResultErr(results, err)
+	}
+	defer srcCleanup()
+
+	for i, job := range jobs {
+		p := plans[i]
+		shrink := p.shrink
+		if p.shrinkOnLoad > sharedShrinkOnLoad {
+			// src was decoded at a higher resolution than this job needs;
+			// fold the difference into the integral vips_shrink step instead
+			// of reloading from buf. Both factors only ever take values in
+			// {1, 2, 4, 8}, so the ratio is always an integer.
+			shrink *= p.shrinkOnLoad / sharedShrinkOnLoad
+		}
+		data, rerr := resizeStage2(src, buf, p.width, p.height, shrink, p.residual, job.Options)
+		results[i] = Result{Data: data, Err: rerr}
+	}
+
+	return results
+}
+
+func fillResultErr(results []Result, err error) []Result {
+	for i := range results {
+		results[i] = Result{Err: err}
+	}
+	return results
+}
+
+func vipsResize(buf []byte, o Options) ([]byte, error) {
 	started := time.Now()
 
-	// start reading just 2 bytes
-	buf := make([]byte, 2)
-	_, err := reader.Read(buf)
+	if len(buf) < 12 {
+		return nil, errors.New("unknown image format")
+	}
+
+	typ := detectImageType(buf)
+	if typ == UNKNOWN {
+		return nil, errors.New("unknown image format")
+	}
+
+	oriented, orientation, cleanup, err := loadAndOrient(buf, typ, o)
 	if err != nil {
 		return nil, err
 	}
+	defer cleanup()
 
-	// detect (if possible) the file type
-	typ := UNKNOWN
-	switch {
-	case buf[0] == MARKER_JPEG[0] && buf[1] == MARKER_JPEG[1]:
-		typ = JPEG
-	case buf[0] == MARKER_PNG[0] && buf[1] == MARKER_PNG[1]:
-		typ = PNG
-	default:
-		return nil, errors.New("unknown image format")
+	debug("options: %+v", o)
+
+	width, height, shrink, residual, shrinkOnLoad := calcShrink(int(oriented.Xsize), int(oriented.Ysize), typ, o)
+
+	src, srcCleanup, err := loadShrunk(buf, typ, orientation, shrinkOnLoad, oriented)
+	if err != nil {
+		return nil, err
 	}
+	defer srcCleanup()
 
-	// now we can read everything
-	rest, err := ioutil.ReadAll(reader)
+	out, err := resizeStage2(src, buf, width, height, shrink, residual, o)
 	if err != nil {
 		return nil, err
 	}
-	buf = append(buf, rest...)
 
-	// create an image instance
-	in := C.vips_image_new()
-	defer C.im_close(in)
-	defer C.vips_error_clear()
+	if DEBUG { // avoid time of calculate the difference
+		debug("done in %s", time.Since(started))
+	}
 
-	// defaults
-	if o.Quality == 0 {
-		o.Quality = 100
+	return out, nil
+}
+
+// loadAndOrient loads buf (of the given, already-detected type) into a
+// VipsImage and, unless o.NoAutoRotate is set, corrects it for its EXIF
+// orientation. The caller must call cleanup once done with the returned
+// image.
+func loadAndOrient(buf []byte, typ ImageType, o Options) (oriented *C.VipsImage, orientation int, cleanup func(), err error) {
+	in := C.vips_image_new()
+	loadBuffer(buf, typ, &in)
+	cleanup = func() {
+		C.im_close(in)
+		C.vips_error_clear()
 	}
 
-	// feed it
-	switch typ {
-	case JPEG:
-		C.vips_jpegload_buffer_rand(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &in)
-	case PNG:
-		C.vips_pngload_buffer_rand(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &in)
+	orientation = 1
+	if !o.NoAutoRotate {
+		orientation = orientationOf(in)
 	}
 
-	debug("options: %+v", o)
+	oriented = in
+	if orientation > 1 {
+		oriented, err = applyOrientation(in, orientation)
+		if err != nil {
+			cleanup()
+			return nil, 0, func() {}, err
+		}
+		prev := cleanup
+		cleanup = func() { C.im_close(oriented); prev() }
+	}
 
-	// get WxH
-	inWidth := int(in.Xsize)
-	inHeight := int(in.Ysize)
+	return oriented, orientation, cleanup, nil
+}
 
-	// prepare for factor
+// calcShrink works out the resize target (width, height), the integral
+// vips_shrink factor and residual vips_affine scale needed to reach it, and
+// the shrink-on-load factor (1, 2, 4 or 8) that the JPEG/WebP loader should
+// be asked for. It depends only on the source's pre-rotation dimensions and
+// type, so ResizeBatch can call it per job without re-decoding anything.
+func calcShrink(inWidth, inHeight int, typ ImageType, o Options) (width, height, shrink int, residual float64, shrinkOnLoad int) {
 	factor := 0.0
 
+	// width/height are the resize target. An Extract request supplies its own
+	// rectangle via Top/Left/Width/Height further down the pipeline, so it
+	// leaves the resize stage alone by targeting the identity transform.
+	width, height = o.Width, o.Height
+	if o.Extract {
+		width, height = 0, 0
+	}
+
 	// image calculations
 	switch {
 	// Fixed width and height
-	case o.Width > 0 && o.Height > 0:
-		xf := float64(inWidth) / float64(o.Width)
-		yf := float64(inHeight) / float64(o.Height)
+	case width > 0 && height > 0:
+		xf := float64(inWidth) / float64(width)
+		yf := float64(inHeight) / float64(height)
 		if o.Crop {
 			factor = math.Min(xf, yf)
 		} else {
 			factor = math.Max(xf, yf)
 		}
 	// Fixed width, auto height
-	case o.Width > 0:
-		factor = float64(inWidth) / float64(o.Width)
-		o.Height = int(math.Floor(float64(inHeight) / factor))
+	case width > 0:
+		factor = float64(inWidth) / float64(width)
+		height = int(math.Floor(float64(inHeight) / factor))
 	// Fixed height, auto width
-	case o.Height > 0:
-		factor = float64(inWidth) / float64(o.Height)
-		o.Width = int(math.Floor(float64(inWidth) / factor))
+	case height > 0:
+		factor = float64(inHeight) / float64(height)
+		width = int(math.Floor(float64(inWidth) / factor))
 	// Identity transform
 	default:
 		factor = 1
-		o.Width = inWidth
-		o.Height = inHeight
+		width = inWidth
+		height = inHeight
 	}
 
 	// shrink
-	shrink := int(math.Floor(factor))
+	shrink = int(math.Floor(factor))
 	if shrink < 1 {
 		shrink = 1
 	}
 
 	// residual
-	residual := float64(shrink) / factor
+	residual = float64(shrink) / factor
 
 	// Do not enlarge the output if the input width *or* height are already less than the required dimensions
 	if !o.Enlarge {
-		if inWidth < o.Width || inHeight < o.Height {
+		if inWidth < width || inHeight < height {
 			factor = 1
 			shrink = 1
 			residual = 0
-			o.Width = inWidth
-			o.Height = inHeight
+			width = inWidth
+			height = inHeight
 		}
 	}
 
-	// We don't use libjpeg shrink-on-load since we are not applying gamma correction
-	shrinkOnLoad := 1
-	if typ == JPEG {
+	// libjpeg shrink-on-load is safe to use since we are not applying gamma correction.
+	// vips_webpload_buffer takes an identical shrink parameter from libvips 8.3 onwards.
+	webpShrinkOnLoad := C.VIPS_MAJOR_VERSION > 8 || (C.VIPS_MAJOR_VERSION == 8 && C.VIPS_MINOR_VERSION >= 3)
+	shrinkOnLoad = 1
+	if typ == JPEG || (typ == WEBP && webpShrinkOnLoad) {
 		switch {
 		case shrink >= 8:
 			factor = factor / 8
@@ -189,21 +379,112 @@ func Resize(reader io.Reader, o Options) ([]byte, error) {
 		}
 	}
 
-	shrunkOnLoad := C.vips_image_new()
-	defer C.im_close(shrunkOnLoad)
-
 	if shrinkOnLoad > 1 {
 		// Recalculate integral shrink and double residual
 		factor = math.Max(factor, 1.0)
 		shrink = int(math.Floor(factor))
 		residual = float64(shrink) / factor
-		// Reload input using shrink-on-load
-		err := C.vips_jpegload_buffer_shrink(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &shrunkOnLoad, C.int(shrinkOnLoad))
-		if err != 0 {
-			return nil, resizeError()
+	}
+
+	return width, height, shrink, residual, shrinkOnLoad
+}
+
+// loadShrunk reloads buf using shrink-on-load at the given factor (if > 1),
+// re-applying orientation since the reload reads straight from the raw
+// buffer and bypasses the fix already applied to oriented. When
+// shrinkOnLoad is 1, it just copies oriented through unchanged. The caller
+// must call cleanup once done with the returned image.
+func loadShrunk(buf []byte, typ ImageType, orientation, shrinkOnLoad int, oriented *C.VipsImage) (src *C.VipsImage, cleanup func(), err error) {
+	shrunkOnLoad := C.vips_image_new()
+	cleanup = func() { C.im_close(shrunkOnLoad) }
+
+	if shrinkOnLoad > 1 {
+		var cerr C.int
+		switch typ {
+		case WEBP:
+			cerr = C.vips_webpload_buffer_shrink(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &shrunkOnLoad, C.int(shrinkOnLoad))
+		default:
+			cerr = C.vips_jpegload_buffer_shrink(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &shrunkOnLoad, C.int(shrinkOnLoad))
 		}
-	} else {
-		C.vips_copy_0(in, &shrunkOnLoad)
+		if cerr != 0 {
+			cleanup()
+			return nil, func() {}, resizeError()
+		}
+
+		if orientation > 1 {
+			orientedShrunkOnLoad, oerr := applyOrientation(shrunkOnLoad, orientation)
+			if oerr != nil {
+				cleanup()
+				return nil, func() {}, oerr
+			}
+			prev := cleanup
+			cleanup = func() { C.im_close(orientedShrunkOnLoad); prev() }
+			return orientedShrunkOnLoad, cleanup, nil
+		}
+		return shrunkOnLoad, cleanup, nil
+	}
+
+	C.vips_copy_0(oriented, &shrunkOnLoad)
+	return shrunkOnLoad, cleanup, nil
+}
+
+// vipsEnergySource adapts a VipsImage's raw pixel memory to EnergySource for
+// SMART/ENTROPY gravity, approximating intensity as the average of its
+// first (up to 3) bands.
+type vipsEnergySource struct {
+	mem   unsafe.Pointer
+	width int
+	bands int
+}
+
+// newVipsEnergySource reads in's pixels into memory for SMART/ENTROPY
+// gravity scoring. At assumes one byte per sample, so a non-uchar input
+// (e.g. 16-bit TIFF) is cast down to uchar first. The caller must call the
+// returned cleanup func once done.
+func newVipsEnergySource(in *C.VipsImage) (*vipsEnergySource, func(), error) {
+	cleanup := func() {}
+	if in.BandFmt != C.VIPS_FORMAT_UCHAR {
+		casted := C.vips_image_new()
+		if err := C.vips_cast_0(in, &casted, C.VIPS_FORMAT_UCHAR); err != 0 {
+			return nil, func() {}, resizeError()
+		}
+		cleanup = func() { C.im_close(casted) }
+		in = casted
+	}
+
+	var size C.size_t
+	mem := C.vips_image_write_to_memory(in, &size)
+	width, bands := int(in.Xsize), int(in.Bands)
+	prev := cleanup
+	cleanup = func() {
+		C.g_free(C.gpointer(mem))
+		prev()
+	}
+	return &vipsEnergySource{mem: mem, width: width, bands: bands}, cleanup, nil
+}
+
+func (s *vipsEnergySource) At(x, y int) uint8 {
+	n := s.bands
+	if n > 3 {
+		n = 3
+	}
+	base := uintptr(s.mem) + uintptr((y*s.width+x)*s.bands)
+	sum := 0
+	for i := 0; i < n; i++ {
+		sum += int(*(*byte)(unsafe.Pointer(base + uintptr(i))))
+	}
+	return uint8(sum / n)
+}
+
+// resizeStage2 runs the remainder of the resize pipeline - vips_shrink,
+// vips_affine, crop/embed, extract/rotate/flip and the final colourspace
+// conversion and save - against src, an already shrink-on-load'd and
+// oriented image as produced by loadShrunk. width, height, shrink and
+// residual come from calcShrink. It's split out from vipsResize so
+// ResizeBatch can run it once per job against a single shared src.
+func resizeStage2(src *C.VipsImage, buf []byte, width, height, shrink int, residual float64, o Options) ([]byte, error) {
+	if o.Quality == 0 {
+		o.Quality = 100
 	}
 
 	shrunk := C.vips_image_new()
@@ -211,7 +492,7 @@ func Resize(reader io.Reader, o Options) ([]byte, error) {
 
 	if shrink > 1 {
 		// Use vips_shrink with the integral reduction
-		err := C.vips_shrink_0(shrunkOnLoad, &shrunk, C.double(float64(shrink)), C.double(float64(shrink)))
+		err := C.vips_shrink_0(src, &shrunk, C.double(float64(shrink)), C.double(float64(shrink)))
 		if err != 0 {
 			return nil, resizeError()
 		}
@@ -220,15 +501,15 @@ func Resize(reader io.Reader, o Options) ([]byte, error) {
 		shrunkWidth := int(shrunk.Xsize)
 		shrunkHeight := int(shrunk.Ysize)
 
-		residualx := float64(o.Width) / float64(shrunkWidth)
-		residualy := float64(o.Height) / float64(shrunkHeight)
+		residualx := float64(width) / float64(shrunkWidth)
+		residualy := float64(height) / float64(shrunkHeight)
 		if o.Crop {
 			residual = math.Max(residualx, residualy)
 		} else {
 			residual = math.Min(residualx, residualy)
 		}
 	} else {
-		C.vips_copy_0(shrunkOnLoad, &shrunk)
+		C.vips_copy_0(src, &shrunk)
 	}
 
 	// Use vips_affine with the remaining float part
@@ -255,21 +536,30 @@ func Resize(reader io.Reader, o Options) ([]byte, error) {
 	canvased := C.vips_image_new()
 	defer C.im_close(canvased)
 
-	if affinedWidth != o.Width || affinedHeight != o.Height {
+	if affinedWidth != width || affinedHeight != height {
 		if o.Crop {
 			// Crop
-			left, top := sharpCalcCrop(affinedWidth, affinedHeight, o.Width, o.Height, o.Gravity)
-			o.Width = int(math.Min(float64(affinedWidth), float64(o.Width)))
-			o.Height = int(math.Min(float64(affinedHeight), float64(o.Height)))
-			err := C.vips_extract_area_0(affined, &canvased, C.int(left), C.int(top), C.int(o.Width), C.int(o.Height))
+			var energy EnergySource
+			if o.Gravity == SMART || o.Gravity == ENTROPY {
+				src, energyCleanup, eerr := newVipsEnergySource(affined)
+				if eerr != nil {
+					return nil, eerr
+				}
+				defer energyCleanup()
+				energy = src
+			}
+			left, top := sharpCalcCrop(affinedWidth, affinedHeight, width, height, o.Gravity, energy)
+			width = int(math.Min(float64(affinedWidth), float64(width)))
+			height = int(math.Min(float64(affinedHeight), float64(height)))
+			err := C.vips_extract_area_0(affined, &canvased, C.int(left), C.int(top), C.int(width), C.int(height))
 			if err != 0 {
 				return nil, resizeError()
 			}
 		} else {
 			// Embed
-			left := (o.Width - affinedWidth) / 2
-			top := (o.Height - affinedHeight) / 2
-			err := C.vips_embed_extend(affined, &canvased, C.int(left), C.int(top), C.int(o.Width), C.int(o.Height), C.int(o.Extend))
+			left := (width - affinedWidth) / 2
+			top := (height - affinedHeight) / 2
+			err := C.vips_embed_extend(affined, &canvased, C.int(left), C.int(top), C.int(width), C.int(height), C.int(o.Extend))
 			if err != 0 {
 				return nil, resizeError()
 			}
@@ -278,21 +568,64 @@ func Resize(reader io.Reader, o Options) ([]byte, error) {
 		C.vips_copy_0(affined, &canvased)
 	}
 
+	// Extract/rotate/flip: explicit post-processing ops that apply after the
+	// resize pipeline but before the final colour space conversion.
+	cur := canvased
+
+	if o.Extract {
+		extracted := C.vips_image_new()
+		defer C.im_close(extracted)
+		err := C.vips_extract_area_0(cur, &extracted, C.int(o.Left), C.int(o.Top), C.int(o.Width), C.int(o.Height))
+		if err != 0 {
+			return nil, resizeError()
+		}
+		cur = extracted
+	}
+
+	if o.Rotate != ANGLE_0 {
+		rotated := C.vips_image_new()
+		defer C.im_close(rotated)
+		err := C.vips_rot_0(cur, &rotated, C.VipsAngle(o.Rotate))
+		if err != 0 {
+			return nil, resizeError()
+		}
+		cur = rotated
+	}
+
+	if o.Flip != DIRECTION_NONE {
+		flipped := C.vips_image_new()
+		defer C.im_close(flipped)
+		err := C.vips_flip_0(cur, &flipped, o.Flip.vipsDirection())
+		if err != 0 {
+			return nil, resizeError()
+		}
+		cur = flipped
+	}
+
 	// Always convert to sRGB colour space
 	colourspaced := C.vips_image_new()
 	defer C.im_close(colourspaced)
 
-	C.vips_colourspace_0(canvased, &colourspaced, C.VIPS_INTERPRETATION_sRGB)
+	C.vips_colourspace_0(cur, &colourspaced, C.VIPS_INTERPRETATION_sRGB)
 
-	// Finally save
+	// Finally save, in the requested (or detected) format
 	output := colourspaced
 
+	if o.Format == UNKNOWN {
+		o.Format = detectImageType(buf)
+	}
+
 	length := C.size_t(0)
 	ptr := unsafe.Pointer(&buf[0])
-	C.vips_jpegsave_custom(output, &ptr, &length, 1, C.int(o.Quality), 0)
-
-	if DEBUG { // avoid time of calculate the difference
-		debug("done in %s", time.Since(started))
+	switch o.Format {
+	case PNG:
+		C.vips_pngsave_custom(output, &ptr, &length, 1, C.int(o.Quality), 0)
+	case WEBP:
+		C.vips_webpsave_custom(output, &ptr, &length, 1, C.int(o.Quality), 0)
+	case TIFF:
+		C.vips_tiffsave_custom(output, &ptr, &length, 1, C.int(o.Quality), 0)
+	default:
+		C.vips_jpegsave_custom(output, &ptr, &length, 1, C.int(o.Quality), 0)
 	}
 
 	return C.GoBytes(ptr, C.int(length)), nil
@@ -305,34 +638,31 @@ func resizeError() error {
 	return errors.New(s)
 }
 
-type Gravity int
-
-const (
-	CENTRE Gravity = iota
-	NORTH
-	EAST
-	SOUTH
-	WEST
-)
+// Metadata inspects the image header without applying any transform.
+func (img *Image) Metadata() (*Metadata, error) {
+	if len(img.buf) < 12 {
+		return nil, errors.New("unknown image format")
+	}
 
-func sharpCalcCrop(inWidth, inHeight, outWidth, outHeight int, gravity Gravity) (int, int) {
-	left, top := 0, 0
-	switch gravity {
-	case NORTH:
-		left = (inWidth - outWidth + 1) / 2
-	case EAST:
-		left = inWidth - outWidth
-		top = (inHeight - outHeight + 1) / 2
-	case SOUTH:
-		left = (inWidth - outWidth + 1) / 2
-		top = inHeight - outHeight
-	case WEST:
-		top = (inHeight - outHeight + 1) / 2
-	default:
-		left = (inWidth - outWidth + 1) / 2
-		top = (inHeight - outHeight + 1) / 2
+	typ := detectImageType(img.buf)
+	if typ == UNKNOWN {
+		return nil, errors.New("unknown image format")
 	}
-	return left, top
+
+	in := C.vips_image_new()
+	defer C.im_close(in)
+	defer C.vips_error_clear()
+
+	loadBuffer(img.buf, typ, &in)
+
+	return &Metadata{
+		Width:       int(in.Xsize),
+		Height:      int(in.Ysize),
+		Channels:    int(in.Bands),
+		Space:       int(in.Type),
+		HasAlpha:    C.vips_image_hasalpha(in) != 0,
+		Orientation: orientationOf(in),
+	}, nil
 }
 
 func debug(format string, args ...interface{}) {
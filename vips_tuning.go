@@ -0,0 +1,35 @@
+//go:build cgo
+
+package vips
+
+/*
+#cgo pkg-config: vips
+#include "vips.h"
+*/
+import "C"
+
+// SetCacheMaxMem sets libvips' operation cache limit in bytes. init()
+// defaults this to 100MB.
+func SetCacheMaxMem(bytes int) {
+	C.vips_cache_set_max_mem(C.size_t(bytes))
+}
+
+// SetCacheMax sets the maximum number of operations libvips' cache holds.
+// init() defaults this to 500.
+func SetCacheMax(n int) {
+	C.vips_cache_set_max(C.int(n))
+}
+
+// SetCacheMaxFiles sets the maximum number of open files libvips' cache
+// holds.
+func SetCacheMaxFiles(n int) {
+	C.vips_cache_set_max_files(C.int(n))
+}
+
+// ThreadShutdown releases the libvips thread-local resources (caches,
+// thread pools) of the calling goroutine's underlying OS thread. Long-running
+// servers that run resizes across many goroutines should call this
+// periodically (e.g. from a worker's idle loop) to bound per-thread memory.
+func ThreadShutdown() {
+	C.vips_thread_shutdown()
+}